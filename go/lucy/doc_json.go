@@ -0,0 +1,149 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lucy
+
+/*
+#include "lucy_parcel.h"
+#include "Lucy/Document/Doc.h"
+#include "Lucy/Plan/Schema.h"
+#include "Lucy/Plan/FieldType.h"
+#include "Lucy/Plan/VectorType.h"
+*/
+import "C"
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"unsafe"
+
+	"git-wip-us.apache.org/repos/asf/lucy-clownfish.git/runtime/go/clownfish"
+)
+
+// MarshalJSON emits the Doc's fields as a canonical JSON object keyed by
+// field name: text as a JSON string, INT32/INT64 and FLOAT32/FLOAT64 as a
+// JSON number, and BLOB as a base64 string.
+func (d Doc) MarshalJSON() ([]byte, error) {
+	ivars := C.lucy_Doc_IVARS((*C.lucy_Doc)(d.TOPTR()))
+	fields := docFieldMap(ivars)
+	out := make(map[string]interface{}, len(fields))
+	for name, value := range fields {
+		if blob, ok := value.([]byte); ok {
+			out[name] = base64.StdEncoding.EncodeToString(blob)
+			continue
+		}
+		out[name] = value
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON stores each field decoded from data in the Doc,
+// overwriting any field of the same name; fields not present in data are
+// kept. It doesn't have a Schema to validate against; use DocFromJSON to get
+// the same type-checking Invert_Doc applies to programmatically
+// constructed Docs.
+func (d Doc) UnmarshalJSON(data []byte) error {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	ivars := C.lucy_Doc_IVARS((*C.lucy_Doc)(d.TOPTR()))
+	fields := docFieldMap(ivars)
+	for name, value := range raw {
+		fields[name] = value
+	}
+	return nil
+}
+
+// DocFromJSON decodes data into a new Doc, using schema's
+// FType_Primitive_ID for each field to decide how its JSON value is
+// decoded and validated, so bulk-ingesting a stream of JSON documents
+// raises the same errors as programmatic construction. Vector fields are
+// not decoded: a JSON value for a vector field returns an error.
+func DocFromJSON(schema Schema, data []byte) (Doc, error) {
+	var raw map[string]json.RawMessage
+	doc := NewDoc(0)
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return doc, err
+	}
+	ivars := C.lucy_Doc_IVARS((*C.lucy_Doc)(doc.TOPTR()))
+	fields := docFieldMap(ivars)
+	schemaCF := (*C.lucy_Schema)(schema.TOPTR())
+	for name, rawValue := range raw {
+		cname := C.CString(name)
+		fieldName := C.cfish_Str_new_from_trusted_utf8(cname, C.size_t(len(name)))
+		fieldType := C.LUCY_Schema_Fetch_Type(schemaCF, fieldName)
+		C.cfish_decref(unsafe.Pointer(fieldName))
+		C.free(unsafe.Pointer(cname))
+		if fieldType == nil {
+			return doc, clownfish.NewErr("Unknown field name: '" + name + "'")
+		}
+		value, err := jsonValueForField(name, rawValue, fieldType)
+		if err != nil {
+			return doc, err
+		}
+		fields[name] = value
+	}
+	return doc, nil
+}
+
+func jsonValueForField(name string, raw json.RawMessage, fieldType *C.lucy_FieldType) (interface{}, error) {
+	invalidType := func() error {
+		className := C.cfish_Obj_get_class_name((*C.cfish_Obj)(unsafe.Pointer(fieldType)))
+		return clownfish.NewErr(fmt.Sprintf("Invalid type for field '%s': '%s'", name,
+			clownfish.CFStringToGo(unsafe.Pointer(className))))
+	}
+	switch C.LUCY_FType_Primitive_ID(fieldType) & C.lucy_FType_PRIMITIVE_ID_MASK {
+	case C.lucy_FType_TEXT:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, invalidType()
+		}
+		return s, nil
+	case C.lucy_FType_INT32:
+		var n int64
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return nil, invalidType()
+		}
+		return int32(n), nil
+	case C.lucy_FType_INT64:
+		var n int64
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return nil, invalidType()
+		}
+		return n, nil
+	case C.lucy_FType_FLOAT32, C.lucy_FType_FLOAT64:
+		var f float64
+		if err := json.Unmarshal(raw, &f); err != nil {
+			return nil, invalidType()
+		}
+		return f, nil
+	case C.lucy_FType_BLOB:
+		var encoded string
+		if err := json.Unmarshal(raw, &encoded); err != nil {
+			return nil, invalidType()
+		}
+		buf, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, invalidType()
+		}
+		return buf, nil
+	case C.lucy_FType_VECTOR:
+		return nil, clownfish.NewErr(fmt.Sprintf("Field '%s': vector fields aren't supported via JSON yet", name))
+	default:
+		return nil, clownfish.NewErr(fmt.Sprintf("Internal Lucy error: bad type id for field '%s'", name))
+	}
+}
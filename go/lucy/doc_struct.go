@@ -0,0 +1,128 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lucy
+
+/*
+#include "lucy_parcel.h"
+#include "Lucy/Document/Doc.h"
+*/
+import "C"
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// StoreStruct walks v's exported fields by reflection and stores each one
+// under its field name (or the name given by a `lucy:"..."` tag), the
+// write-side counterpart of the reflection Hits.Next already does to fill
+// a caller's destination struct. A field tagged `lucy:"name,omitempty"`
+// is skipped when it holds its zero value.
+func (d Doc) StoreStruct(v interface{}) error {
+	ivars := C.lucy_Doc_IVARS((*C.lucy_Doc)(d.TOPTR()))
+	return storeStructFields(docFieldMap(ivars), v)
+}
+
+// NewDocFromStruct builds a new Doc with the given doc ID and populates it
+// from v; it's equivalent to calling NewDoc followed by StoreStruct.
+func NewDocFromStruct(docID int32, v interface{}) (Doc, error) {
+	doc := NewDoc(docID)
+	if err := doc.StoreStruct(v); err != nil {
+		return doc, err
+	}
+	return doc, nil
+}
+
+func storeStructFields(fields map[string]interface{}, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("lucy: StoreStruct needs a struct or a pointer to one, got %T", v)
+	}
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		if sf.PkgPath != "" {
+			continue // unexported
+		}
+		name, omitempty := structFieldName(sf)
+		if name == "-" {
+			continue
+		}
+		fv := rv.Field(i)
+		if omitempty && fv.IsZero() {
+			continue
+		}
+		value, err := structFieldValue(fv)
+		if err != nil {
+			return fmt.Errorf("lucy: field %q: %w", sf.Name, err)
+		}
+		fields[name] = value
+	}
+	return nil
+}
+
+func structFieldName(sf reflect.StructField) (name string, omitempty bool) {
+	name = sf.Name
+	tag := sf.Tag.Get("lucy")
+	if tag == "" {
+		return name, false
+	}
+	parts := strings.Split(tag, ",")
+	if parts[0] != "" {
+		name = parts[0]
+	}
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitempty = true
+		}
+	}
+	return name, omitempty
+}
+
+// structFieldValue dispatches on reflect.Kind to the Go value Doc stores
+// natively for each field type: strings to CFISH_STRING, ints to
+// CFISH_INTEGER, floats to CFISH_FLOAT, and []byte to CFISH_BLOB once
+// goToCFObj needs a Clownfish view of it.
+func structFieldValue(fv reflect.Value) (interface{}, error) {
+	switch fv.Kind() {
+	case reflect.String:
+		return fv.String(), nil
+	case reflect.Int32:
+		return int32(fv.Int()), nil
+	case reflect.Int, reflect.Int64:
+		return fv.Int(), nil
+	case reflect.Float32:
+		return float32(fv.Float()), nil
+	case reflect.Float64:
+		return fv.Float(), nil
+	case reflect.Slice:
+		switch fv.Type().Elem().Kind() {
+		case reflect.Uint8:
+			return fv.Bytes(), nil
+		case reflect.Float32:
+			// Dense vector fields; stored natively and validated
+			// against the Schema's declared dimension by Invert_Doc.
+			return fv.Interface().([]float32), nil
+		case reflect.String:
+			return nil, fmt.Errorf("multi-value text fields aren't supported yet")
+		}
+	}
+	return nil, fmt.Errorf("unsupported Go kind %s", fv.Kind())
+}
@@ -0,0 +1,155 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lucy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStoreStructFieldsUsesTagName(t *testing.T) {
+	type doc struct {
+		Title string `lucy:"title"`
+		Body  string
+	}
+	fields := make(map[string]interface{})
+	if err := storeStructFields(fields, &doc{Title: "hello", Body: "world"}); err != nil {
+		t.Fatalf("storeStructFields returned %v", err)
+	}
+	want := map[string]interface{}{"title": "hello", "Body": "world"}
+	if !reflect.DeepEqual(fields, want) {
+		t.Fatalf("storeStructFields = %v, want %v", fields, want)
+	}
+}
+
+func TestStoreStructFieldsOmitsUnexportedAndDashed(t *testing.T) {
+	type doc struct {
+		Title  string `lucy:"-"`
+		hidden string
+		Body   string
+	}
+	fields := make(map[string]interface{})
+	if err := storeStructFields(fields, &doc{Title: "skip", hidden: "skip", Body: "kept"}); err != nil {
+		t.Fatalf("storeStructFields returned %v", err)
+	}
+	want := map[string]interface{}{"Body": "kept"}
+	if !reflect.DeepEqual(fields, want) {
+		t.Fatalf("storeStructFields = %v, want %v", fields, want)
+	}
+}
+
+func TestStoreStructFieldsOmitempty(t *testing.T) {
+	type doc struct {
+		Title string `lucy:"title,omitempty"`
+		Count int32  `lucy:"count,omitempty"`
+	}
+	fields := make(map[string]interface{})
+	if err := storeStructFields(fields, &doc{}); err != nil {
+		t.Fatalf("storeStructFields returned %v", err)
+	}
+	if len(fields) != 0 {
+		t.Fatalf("expected zero-valued omitempty fields to be skipped, got %v", fields)
+	}
+}
+
+func TestStoreStructFieldsRejectsNonStruct(t *testing.T) {
+	if err := storeStructFields(make(map[string]interface{}), 42); err == nil {
+		t.Fatal("expected an error storing a non-struct value")
+	}
+}
+
+func TestStructFieldName(t *testing.T) {
+	type doc struct {
+		Plain   string
+		Renamed string `lucy:"renamed"`
+		Skipped string `lucy:"-"`
+		Opt     string `lucy:"opt,omitempty"`
+	}
+	rt := reflect.TypeOf(doc{})
+	tests := []struct {
+		field         string
+		wantName      string
+		wantOmitempty bool
+	}{
+		{"Plain", "Plain", false},
+		{"Renamed", "renamed", false},
+		{"Skipped", "-", false},
+		{"Opt", "opt", true},
+	}
+	for _, tt := range tests {
+		sf, _ := rt.FieldByName(tt.field)
+		name, omitempty := structFieldName(sf)
+		if name != tt.wantName || omitempty != tt.wantOmitempty {
+			t.Errorf("structFieldName(%s) = (%q, %v), want (%q, %v)",
+				tt.field, name, omitempty, tt.wantName, tt.wantOmitempty)
+		}
+	}
+}
+
+func TestStructFieldValue(t *testing.T) {
+	type doc struct {
+		Str   string
+		I32   int32
+		I     int
+		F32   float32
+		F64   float64
+		Blob  []byte
+		Embed []float32
+	}
+	d := doc{
+		Str:   "hi",
+		I32:   7,
+		I:     8,
+		F32:   1.5,
+		F64:   2.5,
+		Blob:  []byte{1, 2, 3},
+		Embed: []float32{0.1, 0.2},
+	}
+	rv := reflect.ValueOf(d)
+	cases := []struct {
+		field string
+		want  interface{}
+	}{
+		{"Str", "hi"},
+		{"I32", int32(7)},
+		{"I", int64(8)},
+		{"F32", float32(1.5)},
+		{"F64", 2.5},
+		{"Blob", []byte{1, 2, 3}},
+		{"Embed", []float32{0.1, 0.2}},
+	}
+	for _, tt := range cases {
+		got, err := structFieldValue(rv.FieldByName(tt.field))
+		if err != nil {
+			t.Errorf("structFieldValue(%s) returned %v", tt.field, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("structFieldValue(%s) = %#v, want %#v", tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestStructFieldValueRejectsMultiValueText(t *testing.T) {
+	type doc struct {
+		Tags []string
+	}
+	rv := reflect.ValueOf(doc{Tags: []string{"a", "b"}})
+	if _, err := structFieldValue(rv.FieldByName("Tags")); err == nil {
+		t.Fatal("expected an error for a []string field")
+	}
+}
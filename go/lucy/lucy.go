@@ -21,18 +21,35 @@ package lucy
 #define C_LUCY_REGEXTOKENIZER
 #define C_LUCY_INVERTER
 #define C_LUCY_INVERTERENTRY
+#define C_LUCY_VECTORWRITER
+#define C_LUCY_VECTORREADER
+#define C_LUCY_VECTORMATCHER
 
 #include "lucy_parcel.h"
 #include "Lucy/Analysis/RegexTokenizer.h"
+#include "Lucy/Analysis/Token.h"
+#include "Lucy/Analysis/Inversion.h"
 #include "Lucy/Document/Doc.h"
 #include "Lucy/Index/Inverter.h"
 
+#include <stdlib.h>
+
 #include "Clownfish/Hash.h"
 #include "Clownfish/HashIterator.h"
 #include "Clownfish/Vector.h"
+#include "Clownfish/Blob.h"
+#include "Clownfish/Integer.h"
+#include "Clownfish/Float.h"
+#include "Clownfish/String.h"
 #include "Lucy/Plan/FieldType.h"
+#include "Lucy/Plan/VectorType.h"
 #include "Lucy/Plan/Schema.h"
+#include "Lucy/Index/DataWriter.h"
 #include "Lucy/Index/Segment.h"
+#include "Lucy/Index/VectorWriter.h"
+#include "Lucy/Index/VectorReader.h"
+#include "Lucy/Index/VectorMatcher.h"
+#include "Lucy/Store/Folder.h"
 #include "Lucy/Store/InStream.h"
 #include "Lucy/Store/OutStream.h"
 #include "Lucy/Util/Freezer.h"
@@ -95,6 +112,36 @@ GOLUCY_Inverter_Invert_Doc(lucy_Inverter *self, lucy_Doc *doc);
 extern void
 (*GOLUCY_Inverter_Invert_Doc_BRIDGE)(lucy_Inverter *self, lucy_Doc *doc);
 
+extern void
+GOLUCY_VectorWriter_Finish(lucy_VectorWriter *self);
+extern void
+(*GOLUCY_VectorWriter_Finish_BRIDGE)(lucy_VectorWriter *self);
+
+extern void
+GOLUCY_VectorReader_Close(lucy_VectorReader *self);
+extern void
+(*GOLUCY_VectorReader_Close_BRIDGE)(lucy_VectorReader *self);
+
+extern lucy_VectorMatcher*
+GOLUCY_VectorMatcher_init(lucy_VectorMatcher *self, lucy_Folder *folder, lucy_Segment *segment,
+						   int32_t field_num, float *query_vec, int32_t dim, int32_t k);
+extern lucy_VectorMatcher*
+(*GOLUCY_VectorMatcher_init_BRIDGE)(lucy_VectorMatcher *self, lucy_Folder *folder,
+									 lucy_Segment *segment, int32_t field_num, float *query_vec,
+									 int32_t dim, int32_t k);
+extern int32_t
+GOLUCY_VectorMatcher_Next(lucy_VectorMatcher *self);
+extern int32_t
+(*GOLUCY_VectorMatcher_Next_BRIDGE)(lucy_VectorMatcher *self);
+extern float
+GOLUCY_VectorMatcher_Score(lucy_VectorMatcher *self);
+extern float
+(*GOLUCY_VectorMatcher_Score_BRIDGE)(lucy_VectorMatcher *self);
+extern void
+GOLUCY_VectorMatcher_Destroy(lucy_VectorMatcher *self);
+extern void
+(*GOLUCY_VectorMatcher_Destroy_BRIDGE)(lucy_VectorMatcher *self);
+
 
 // C symbols linked into a Go-built package archive are not visible to
 // external C code -- but internal code *can* see symbols from outside.
@@ -116,13 +163,28 @@ GOLUCY_glue_exported_symbols() {
 	GOLUCY_Doc_Equals_BRIDGE = GOLUCY_Doc_Equals;
 	GOLUCY_Doc_Destroy_BRIDGE = GOLUCY_Doc_Destroy;
 	GOLUCY_Inverter_Invert_Doc_BRIDGE = GOLUCY_Inverter_Invert_Doc;
+	GOLUCY_VectorWriter_Finish_BRIDGE = GOLUCY_VectorWriter_Finish;
+	GOLUCY_VectorReader_Close_BRIDGE = GOLUCY_VectorReader_Close;
+	GOLUCY_VectorMatcher_init_BRIDGE = GOLUCY_VectorMatcher_init;
+	GOLUCY_VectorMatcher_Next_BRIDGE = GOLUCY_VectorMatcher_Next;
+	GOLUCY_VectorMatcher_Score_BRIDGE = GOLUCY_VectorMatcher_Score;
+	GOLUCY_VectorMatcher_Destroy_BRIDGE = GOLUCY_VectorMatcher_Destroy;
 }
 
 */
 import "C"
 import "unsafe"
 import "fmt"
+import "regexp"
+import "unicode/utf8"
+import "reflect"
+import "sync"
 import "git-wip-us.apache.org/repos/asf/lucy-clownfish.git/runtime/go/clownfish"
+import "git-wip-us.apache.org/repos/asf/lucy.git/go/lucy/search"
+
+// defaultTokenRegex mirrors the default pattern the Perl and C hosts use
+// when a RegexTokenizer is constructed without an explicit pattern.
+const defaultTokenRegex = `\w+`
 
 func init() {
 	C.GOLUCY_glue_exported_symbols()
@@ -131,16 +193,89 @@ func init() {
 
 //export GOLUCY_RegexTokenizer_init
 func GOLUCY_RegexTokenizer_init(rt *C.lucy_RegexTokenizer, pattern *C.cfish_String) *C.lucy_RegexTokenizer {
-	return nil
+	ivars := C.lucy_RegexTokenizer_IVARS(rt)
+	patternGo := defaultTokenRegex
+	if pattern != nil {
+		ivars.pattern = (*C.cfish_String)(unsafe.Pointer(C.cfish_inc_refcount(unsafe.Pointer(pattern))))
+		patternGo = clownfish.CFStringToGo(unsafe.Pointer(pattern))
+	} else {
+		cstr := C.CString(patternGo)
+		ivars.pattern = C.cfish_Str_new_from_trusted_utf8(cstr, C.size_t(len(patternGo)))
+		C.free(unsafe.Pointer(cstr))
+	}
+	compiled, err := regexp.Compile(patternGo)
+	if err != nil {
+		panic(clownfish.NewErr(fmt.Sprintf("Invalid regex pattern '%s': %s", patternGo, err)))
+	}
+	// The compiled *regexp.Regexp can't be stored in a cfish_Obj, so it
+	// lives in the Go-side registry, keyed off this RegexTokenizer's own
+	// address since (unlike Doc) it has no spare ivar to stash a handle
+	// in.
+	registryStoreAtPtr(unsafe.Pointer(rt), compiled)
+	return rt
 }
 
 //export GOLUCY_RegexTokenizer_Destroy
 func GOLUCY_RegexTokenizer_Destroy(rt *C.lucy_RegexTokenizer) {
+	registryDeleteAtPtr(unsafe.Pointer(rt))
+	ivars := C.lucy_RegexTokenizer_IVARS(rt)
+	C.cfish_decref(unsafe.Pointer(ivars.pattern))
+	C.cfish_super_destroy(unsafe.Pointer(rt), C.LUCY_REGEXTOKENIZER)
 }
 
 //export GOLUCY_RegexTokenizer_Tokenize_Utf8
 func GOLUCY_RegexTokenizer_Tokenize_Utf8(rt *C.lucy_RegexTokenizer, str *C.char,
 	stringLen C.size_t, inversion *C.lucy_Inversion) {
+	compiled, _ := registryFetchAtPtr(unsafe.Pointer(rt)).(*regexp.Regexp)
+	if compiled == nil || stringLen == 0 {
+		return
+	}
+	buf := C.GoBytes(unsafe.Pointer(str), C.int(stringLen))
+	charOffsets := utf8CharOffsets(buf)
+
+	for _, span := range regexTokenSpans(compiled, buf) {
+		start, end := span[0], span[1]
+		token := C.lucy_Token_new((*C.char)(unsafe.Pointer(&buf[start])),
+			C.size_t(end-start), C.int32_t(charOffsets[start]),
+			C.int32_t(charOffsets[end]), C.float(1.0), C.int32_t(1))
+		C.LUCY_Inversion_Append(inversion, token)
+	}
+}
+
+// regexTokenSpans returns the byte-offset [start, end) spans
+// RegexTokenizer should emit as tokens: every non-empty match of pattern
+// against buf, in order. Empty matches (e.g. `x*` against text with no
+// "x") are dropped since they don't correspond to a real token.
+func regexTokenSpans(pattern *regexp.Regexp, buf []byte) [][2]int {
+	matches := pattern.FindAllIndex(buf, -1)
+	spans := make([][2]int, 0, len(matches))
+	for _, m := range matches {
+		if m[0] == m[1] {
+			continue
+		}
+		spans = append(spans, [2]int{m[0], m[1]})
+	}
+	return spans
+}
+
+// utf8CharOffsets returns, for every byte offset in buf that starts a
+// character (and for len(buf)), the number of UTF-8 characters that
+// precede it; offsets inside a multi-byte character are left at 0. It is
+// built in one left-to-right scan so
+// RegexTokenizer can report match offsets to Inversion_Append as
+// character offsets (matching the Perl and C hosts) rather than byte
+// offsets.
+func utf8CharOffsets(buf []byte) []int32 {
+	charOffsets := make([]int32, len(buf)+1)
+	var charNum int32
+	for byteNum := 0; byteNum < len(buf); {
+		charOffsets[byteNum] = charNum
+		_, size := utf8.DecodeRune(buf[byteNum:])
+		byteNum += size
+		charNum++
+	}
+	charOffsets[len(buf)] = charNum
+	return charOffsets
 }
 
 func NewDoc(docID int32) Doc {
@@ -148,13 +283,91 @@ func NewDoc(docID int32) Doc {
 	return WRAPDoc(unsafe.Pointer(retvalCF))
 }
 
+// docFieldMap fetches the Go-side field map for a Doc out of the handle
+// stashed in ivars.fields.
+func docFieldMap(ivars *C.lucy_DocIVARS) map[string]interface{} {
+	return fieldsEntry(ivars.fields).values
+}
+
+// cfObjToGo converts a Clownfish scalar into the native Go value Doc
+// stores internally, so that once a field crosses the CGO boundary it is
+// held as a Go value; goToCFObj converts it back only when a Clownfish
+// view is needed.
+func cfObjToGo(obj *C.cfish_Obj) interface{} {
+	if obj == nil {
+		return nil
+	}
+	switch {
+	case C.cfish_Obj_is_a(obj, C.CFISH_STRING):
+		return clownfish.CFStringToGo(unsafe.Pointer(obj))
+	case C.cfish_Obj_is_a(obj, C.CFISH_INTEGER):
+		return int64(C.CFISH_Int_Get_Value((*C.cfish_Integer)(unsafe.Pointer(obj))))
+	case C.cfish_Obj_is_a(obj, C.CFISH_FLOAT):
+		return float64(C.CFISH_Float_Get_Value((*C.cfish_Float)(unsafe.Pointer(obj))))
+	case C.cfish_Obj_is_a(obj, C.CFISH_BLOB):
+		blob := (*C.cfish_Blob)(unsafe.Pointer(obj))
+		size := C.CFISH_Blob_Get_Size(blob)
+		buf := C.CFISH_Blob_Get_Buf(blob)
+		return C.GoBytes(unsafe.Pointer(buf), C.int(size))
+	default:
+		// Not a scalar type Doc knows how to flatten -- keep the
+		// Clownfish object itself rather than losing it.
+		return obj
+	}
+}
+
+// goToCFObj is the inverse of cfObjToGo, used only when a Clownfish view
+// of a field is actually needed (Serialize, Extract, Equals, Invert_Doc). Every
+// branch except the passthrough one builds a brand-new object that the
+// caller already owns outright (refcount 1, same as
+// cfish_Str_new_from_trusted_utf8 in GOLUCY_RegexTokenizer_init); callers
+// must take ownership of the result directly rather than increffing it
+// again. The passthrough case is the one exception: *C.cfish_Obj values
+// come from fields that were never flattened to a Go scalar (cfObjToGo's
+// default branch), so the object is still borrowed from wherever it came
+// from and needs its own incref here.
+//
+// []float32 has no Clownfish scalar counterpart, so it isn't handled
+// here -- callers that loop over a field map generically must special-
+// case vector fields themselves before calling goToCFObj, the way
+// Invert_Doc already does.
+func goToCFObj(value interface{}) *C.cfish_Obj {
+	switch v := value.(type) {
+	case string:
+		cstr := C.CString(v)
+		defer C.free(unsafe.Pointer(cstr))
+		str := C.cfish_Str_new_from_trusted_utf8(cstr, C.size_t(len(v)))
+		return (*C.cfish_Obj)(unsafe.Pointer(str))
+	case int:
+		return (*C.cfish_Obj)(unsafe.Pointer(C.cfish_Int_new(C.int64_t(v))))
+	case int32:
+		return (*C.cfish_Obj)(unsafe.Pointer(C.cfish_Int_new(C.int64_t(v))))
+	case int64:
+		return (*C.cfish_Obj)(unsafe.Pointer(C.cfish_Int_new(C.int64_t(v))))
+	case float32:
+		return (*C.cfish_Obj)(unsafe.Pointer(C.cfish_Float_new(C.double(v))))
+	case float64:
+		return (*C.cfish_Obj)(unsafe.Pointer(C.cfish_Float_new(C.double(v))))
+	case []byte:
+		cbuf := C.CBytes(v)
+		defer C.free(cbuf)
+		blob := C.cfish_Blob_new((*C.char)(cbuf), C.size_t(len(v)))
+		return (*C.cfish_Obj)(unsafe.Pointer(blob))
+	case *C.cfish_Obj:
+		return C.cfish_inc_refcount(unsafe.Pointer(v))
+	default:
+		panic(clownfish.NewErr(fmt.Sprintf("Can't convert a Go %T to a Clownfish object", value)))
+	}
+}
+
 //export GOLUCY_Doc_init
 func GOLUCY_Doc_init(d *C.lucy_Doc, fields unsafe.Pointer, docID C.int32_t) *C.lucy_Doc {
 	ivars := C.lucy_Doc_IVARS(d)
 	if fields != nil {
-		ivars.fields = unsafe.Pointer(C.cfish_inc_refcount(fields))
+		fieldsIncRef(fields)
+		ivars.fields = fields
 	} else {
-		ivars.fields = unsafe.Pointer(C.cfish_Hash_new(0))
+		ivars.fields = newFields()
 	}
 	ivars.doc_id = docID
 	return d
@@ -163,37 +376,63 @@ func GOLUCY_Doc_init(d *C.lucy_Doc, fields unsafe.Pointer, docID C.int32_t) *C.l
 //export GOLUCY_Doc_Set_Fields
 func GOLUCY_Doc_Set_Fields(d *C.lucy_Doc, fields unsafe.Pointer) {
 	ivars := C.lucy_Doc_IVARS(d)
-	temp := ivars.fields
-	ivars.fields = unsafe.Pointer(C.cfish_inc_refcount(fields))
-	C.cfish_decref(temp)
+	oldFields := ivars.fields
+	fieldsIncRef(fields)
+	ivars.fields = fields
+	fieldsRelease(oldFields)
 }
 
 //export GOLUCY_Doc_Get_Size
 func GOLUCY_Doc_Get_Size(d *C.lucy_Doc) C.uint32_t {
 	ivars := C.lucy_Doc_IVARS(d)
-	hash := ((*C.cfish_Hash)(ivars.fields))
-	return C.uint32_t(C.CFISH_Hash_Get_Size(hash))
+	return C.uint32_t(len(docFieldMap(ivars)))
 }
 
 //export GOLUCY_Doc_Store
 func GOLUCY_Doc_Store(d *C.lucy_Doc, field *C.cfish_String, value *C.cfish_Obj) {
 	ivars := C.lucy_Doc_IVARS(d)
-	hash := (*C.cfish_Hash)(ivars.fields)
-	C.CFISH_Hash_Store(hash, field, C.cfish_inc_refcount(unsafe.Pointer(value)))
+	fieldGo := clownfish.CFStringToGo(unsafe.Pointer(field))
+	docFieldMap(ivars)[fieldGo] = cfObjToGo(value)
 }
 
 //export GOLUCY_Doc_Serialize
 func GOLUCY_Doc_Serialize(d *C.lucy_Doc, outstream *C.lucy_OutStream) {
 	ivars := C.lucy_Doc_IVARS(d)
-	hash := (*C.cfish_Hash)(ivars.fields)
+	fields := docFieldMap(ivars)
+	hash := C.cfish_Hash_new(C.size_t(len(fields)))
+	for name, value := range fields {
+		if _, isVector := value.([]float32); isVector {
+			// Vector fields live in VectorWriter's per-segment column,
+			// not the stored-field hash -- goToCFObj has no Clownfish
+			// counterpart for them.
+			continue
+		}
+		cstr := C.CString(name)
+		key := C.cfish_Str_new_from_trusted_utf8(cstr, C.size_t(len(name)))
+		C.CFISH_Hash_Store(hash, key, goToCFObj(value))
+		C.cfish_decref(unsafe.Pointer(key))
+		C.free(unsafe.Pointer(cstr))
+	}
 	C.lucy_Freezer_serialize_hash(hash, outstream)
+	C.cfish_decref(unsafe.Pointer(hash))
 	C.LUCY_OutStream_Write_C32(outstream, C.uint32_t(ivars.doc_id))
 }
 
 //export GOLUCY_Doc_Deserialize
 func GOLUCY_Doc_Deserialize(d *C.lucy_Doc, instream *C.lucy_InStream) *C.lucy_Doc {
 	ivars := C.lucy_Doc_IVARS(d)
-	ivars.fields = unsafe.Pointer(C.lucy_Freezer_read_hash(instream))
+	hash := C.lucy_Freezer_read_hash(instream)
+	fields := make(map[string]interface{})
+	iter := C.cfish_HashIter_new(hash)
+	for C.CFISH_HashIter_Next(iter) {
+		key := C.CFISH_HashIter_Get_Key(iter)
+		val := C.CFISH_HashIter_Get_Value(iter)
+		fields[clownfish.CFStringToGo(unsafe.Pointer(key))] = cfObjToGo(val)
+	}
+	C.cfish_dec_refcount(unsafe.Pointer(iter))
+	C.cfish_decref(unsafe.Pointer(hash))
+	fieldsRelease(ivars.fields)
+	ivars.fields = newFieldsFrom(fields)
 	ivars.doc_id = C.int32_t(C.LUCY_InStream_Read_C32(instream))
 	return d
 }
@@ -201,9 +440,18 @@ func GOLUCY_Doc_Deserialize(d *C.lucy_Doc, instream *C.lucy_InStream) *C.lucy_Do
 //export GOLUCY_Doc_Extract
 func GOLUCY_Doc_Extract(d *C.lucy_Doc, field *C.cfish_String) *C.cfish_Obj {
 	ivars := C.lucy_Doc_IVARS(d)
-	hash := (*C.cfish_Hash)(ivars.fields)
-	val := C.CFISH_Hash_Fetch(hash, field)
-	return C.cfish_inc_refcount(unsafe.Pointer(val))
+	fieldGo := clownfish.CFStringToGo(unsafe.Pointer(field))
+	value, ok := docFieldMap(ivars)[fieldGo]
+	if !ok {
+		return nil
+	}
+	if _, isVector := value.([]float32); isVector {
+		// Vector fields have no Clownfish scalar representation for
+		// Extract to hand back; they're only readable through
+		// VectorReader/KNNQuery.
+		panic(clownfish.NewErr("Can't Extract vector field '" + fieldGo + "' as a Clownfish object"))
+	}
+	return goToCFObj(value)
 }
 
 //export GOLUCY_Doc_Equals
@@ -217,15 +465,42 @@ func GOLUCY_Doc_Equals(d *C.lucy_Doc, other *C.cfish_Obj) C.bool {
 	}
 	ivars := C.lucy_Doc_IVARS(d)
 	ovars := C.lucy_Doc_IVARS(twin)
-	hash := (*C.cfish_Hash)(ivars.fields)
-	otherHash := (*C.cfish_Obj)(ovars.fields)
-	return C.CFISH_Hash_Equals(hash, otherHash)
+	fields := docFieldMap(ivars)
+	otherFields := docFieldMap(ovars)
+	if len(fields) != len(otherFields) {
+		return false
+	}
+	for name, value := range fields {
+		otherValue, ok := otherFields[name]
+		if !ok {
+			return false
+		}
+		if vector, isVector := value.([]float32); isVector {
+			// Vectors have no Clownfish counterpart for goToCFObj to
+			// build; compare the Go slices directly instead.
+			otherVector, ok := otherValue.([]float32)
+			if !ok || !reflect.DeepEqual(vector, otherVector) {
+				return false
+			}
+			continue
+		}
+		lhs := goToCFObj(value)
+		rhs := goToCFObj(otherValue)
+		equal := C.cfish_Obj_is_a(lhs, C.cfish_Obj_get_class(rhs)) &&
+			bool(C.CFISH_Obj_Equals((*C.cfish_Obj)(unsafe.Pointer(lhs)), rhs))
+		C.cfish_decref(unsafe.Pointer(lhs))
+		C.cfish_decref(unsafe.Pointer(rhs))
+		if !equal {
+			return false
+		}
+	}
+	return true
 }
 
 //export GOLUCY_Doc_Destroy
 func GOLUCY_Doc_Destroy(d *C.lucy_Doc) {
 	ivars := C.lucy_Doc_IVARS(d)
-	C.cfish_decref(unsafe.Pointer(ivars.fields))
+	fieldsRelease(ivars.fields)
 	C.cfish_super_destroy(unsafe.Pointer(d), C.LUCY_DOC)
 }
 
@@ -259,28 +534,56 @@ func fetchEntry(ivars *C.lucy_InverterIVARS, field *C.cfish_String) *C.lucy_Inve
 //export GOLUCY_Inverter_Invert_Doc
 func GOLUCY_Inverter_Invert_Doc(inverter *C.lucy_Inverter, doc *C.lucy_Doc) {
 	ivars := C.lucy_Inverter_IVARS(inverter)
-	fields := (*C.cfish_Hash)(C.LUCY_Doc_Get_Fields(doc))
+	docIvars := C.lucy_Doc_IVARS(doc)
+	fields := docFieldMap(docIvars)
 
 	// Prepare for the new doc.
 	C.LUCY_Inverter_Set_Doc(inverter, doc)
 
 	// Extract and invert the doc's fields.
-	iter := C.cfish_HashIter_new(fields)
-	for C.CFISH_HashIter_Next(iter) {
-		field := C.CFISH_HashIter_Get_Key(iter)
-		obj := C.CFISH_HashIter_Get_Value(iter)
-		if obj == nil {
-			mess := "Invalid nil value for field" + clownfish.CFStringToGo(unsafe.Pointer(field))
-			panic(clownfish.NewErr(mess))
+	for fieldName, value := range fields {
+		if value == nil {
+			panic(clownfish.NewErr("Invalid nil value for field " + fieldName))
 		}
+		cstr := C.CString(fieldName)
+		field := C.cfish_Str_new_from_trusted_utf8(cstr, C.size_t(len(fieldName)))
 
 		inventry := fetchEntry(ivars, field)
 		inventryIvars := C.lucy_InvEntry_IVARS(inventry)
 		fieldType := inventryIvars._type
+		primitiveID := C.LUCY_FType_Primitive_ID(fieldType) & C.lucy_FType_PRIMITIVE_ID_MASK
+
+		// Vectors don't go into the posting lists the other primitives
+		// do -- they're a per-segment column (VectorWriter) that
+		// KNNQuery scans directly, so validate the dimensionality
+		// against the schema and hand the row straight to the writer
+		// instead of routing it through InverterEntry/Add_Field.
+		if primitiveID == C.lucy_FType_VECTOR {
+			vector, ok := value.([]float32)
+			if !ok {
+				panic(clownfish.NewErr(fmt.Sprintf("Invalid type for vector field '%s': %T",
+					fieldName, value)))
+			}
+			dim := int(C.LUCY_VectorType_Get_Dim((*C.lucy_VectorType)(unsafe.Pointer(fieldType))))
+			if len(vector) != dim {
+				panic(clownfish.NewErr(fmt.Sprintf(
+					"Field '%s' expects a %d-dimension vector, got %d", fieldName, dim, len(vector))))
+			}
+			fieldNum := C.LUCY_Seg_Field_Num(ivars.segment, field)
+			writer := fetchVectorWriter(ivars.segment, int(fieldNum), dim)
+			if err := writer.Add(int32(docIvars.doc_id), vector); err != nil {
+				panic(clownfish.NewErr(fmt.Sprintf("Field '%s': %s", fieldName, err)))
+			}
+			C.cfish_decref(unsafe.Pointer(field))
+			C.free(unsafe.Pointer(cstr))
+			continue
+		}
+
+		obj := goToCFObj(value)
 
 		// Get the field value.
 		var expectedType *C.cfish_Class
-		switch C.LUCY_FType_Primitive_ID(fieldType) & C.lucy_FType_PRIMITIVE_ID_MASK {
+		switch primitiveID {
 		case C.lucy_FType_TEXT:
 			expectedType = C.CFISH_STRING
 		case C.lucy_FType_BLOB:
@@ -306,10 +609,248 @@ func GOLUCY_Inverter_Invert_Doc(inverter *C.lucy_Inverter, doc *C.lucy_Doc) {
 		}
 		if inventryIvars.value != obj {
 			C.cfish_decref(unsafe.Pointer(inventryIvars.value))
-			inventryIvars.value = C.cfish_inc_refcount(unsafe.Pointer(obj))
+			inventryIvars.value = obj
 		}
 
 		C.LUCY_Inverter_Add_Field(inverter, inventry)
+		C.cfish_decref(unsafe.Pointer(field))
+		C.free(unsafe.Pointer(cstr))
 	}
-	C.cfish_dec_refcount(unsafe.Pointer(iter))
+}
+
+// VectorWriter accumulates one segment's worth of fixed-dimensionality
+// float32 vectors and flushes them as a single flat array, one row per
+// doc ID -- the vector-field analog of SortWriter's sorted column. This
+// is a first cut: a flat per-segment scan, with room to swap in an HNSW
+// index behind VectorReader later without touching the wire format's
+// consumers.
+type VectorWriter struct {
+	dim  int
+	rows map[int32][]float32
+}
+
+func NewVectorWriter(dim int) *VectorWriter {
+	return &VectorWriter{dim: dim, rows: make(map[int32][]float32)}
+}
+
+func (w *VectorWriter) Add(docID int32, vector []float32) error {
+	if len(vector) != w.dim {
+		return fmt.Errorf("vector has %d dimensions, field expects %d", len(vector), w.dim)
+	}
+	w.rows[docID] = vector
+	return nil
+}
+
+// WriteTo flushes the dimension, a presence bitmap (one byte per doc ID,
+// 1 if that doc stored a value for this field), and finally the flat
+// float32 rows. The bitmap is what lets VectorReader.Vector tell "never
+// indexed" apart from "indexed as all-zero": without it every unindexed
+// doc reads back as a phantom zero vector that KNNMatcher would happily
+// return as a neighbor.
+func (w *VectorWriter) WriteTo(outstream *C.lucy_OutStream, maxDoc int32) {
+	C.LUCY_OutStream_Write_C32(outstream, C.uint32_t(w.dim))
+	for docID := int32(1); docID <= maxDoc; docID++ {
+		var present C.uint8_t
+		if _, ok := w.rows[docID]; ok {
+			present = 1
+		}
+		C.LUCY_OutStream_Write_U8(outstream, present)
+	}
+	zero := make([]float32, w.dim)
+	for docID := int32(1); docID <= maxDoc; docID++ {
+		row, ok := w.rows[docID]
+		if !ok {
+			row = zero
+		}
+		for _, f := range row {
+			C.LUCY_OutStream_Write_F32(outstream, C.float(f))
+		}
+	}
+}
+
+// VectorReader is the read side of VectorWriter: a per-segment flat
+// float32 column that search.KNNQuery scans for cosine similarity.
+type VectorReader struct {
+	dim     int
+	maxDoc  int32
+	present []bool
+	data    []float32
+}
+
+func NewVectorReader(instream *C.lucy_InStream, maxDoc int32) *VectorReader {
+	dim := int(C.LUCY_InStream_Read_C32(instream))
+	present := make([]bool, maxDoc+1)
+	for docID := int32(1); docID <= maxDoc; docID++ {
+		present[docID] = C.LUCY_InStream_Read_U8(instream) != 0
+	}
+	data := make([]float32, int(maxDoc)*dim)
+	for i := range data {
+		data[i] = float32(C.LUCY_InStream_Read_F32(instream))
+	}
+	return &VectorReader{dim: dim, maxDoc: maxDoc, present: present, data: data}
+}
+
+// Vector returns the stored vector for docID, or nil if docID never
+// stored a value for this field, satisfying search.VectorReader.
+func (r *VectorReader) Vector(docID int32) []float32 {
+	if docID < 1 || docID > r.maxDoc || !r.present[docID] {
+		return nil
+	}
+	start := (int(docID) - 1) * r.dim
+	return r.data[start : start+r.dim]
+}
+
+// MaxDoc satisfies search.VectorReader.
+func (r *VectorReader) MaxDoc() int32 {
+	return r.maxDoc
+}
+
+// Per-segment VectorWriters, keyed by segment address and field number so
+// Invert_Doc can accumulate rows across every doc in a segment before
+// WriteTo flushes the column at segment commit, the same lifecycle
+// SortWriter's per-segment state follows.
+var (
+	vectorWriterMutex sync.Mutex
+	vectorWriters     = make(map[uintptr]map[int]*VectorWriter)
+)
+
+func fetchVectorWriter(segment *C.lucy_Segment, fieldNum int, dim int) *VectorWriter {
+	vectorWriterMutex.Lock()
+	defer vectorWriterMutex.Unlock()
+	key := uintptr(unsafe.Pointer(segment))
+	perField, ok := vectorWriters[key]
+	if !ok {
+		perField = make(map[int]*VectorWriter)
+		vectorWriters[key] = perField
+	}
+	writer, ok := perField[fieldNum]
+	if !ok {
+		writer = NewVectorWriter(dim)
+		perField[fieldNum] = writer
+	}
+	return writer
+}
+
+// vectorFilename is the per-field file a segment's vector column is
+// flushed under, mirroring how SortCache and the other per-field
+// DataWriters each get their own file within the segment directory.
+func vectorFilename(fieldNum int) string {
+	return fmt.Sprintf("vector-%d.dat", fieldNum)
+}
+
+//export GOLUCY_VectorWriter_Finish
+func GOLUCY_VectorWriter_Finish(self *C.lucy_VectorWriter) {
+	ivars := C.lucy_VectorWriter_IVARS(self)
+	segment := ivars.segment
+	key := uintptr(unsafe.Pointer(segment))
+
+	// Finish is the DataWriter lifecycle hook the core calls once per
+	// segment, after every doc in it has been inverted -- pull this
+	// segment's writers out of the in-process map here so a long-running
+	// indexer's entry doesn't outlive the segment (and so a reused
+	// segment address can't inherit a stale entry).
+	vectorWriterMutex.Lock()
+	perField := vectorWriters[key]
+	delete(vectorWriters, key)
+	vectorWriterMutex.Unlock()
+
+	maxDoc := C.LUCY_Seg_Get_Count(segment)
+	for fieldNum, writer := range perField {
+		filename := vectorFilename(fieldNum)
+		cstr := C.CString(filename)
+		filenameCF := C.cfish_Str_new_from_trusted_utf8(cstr, C.size_t(len(filename)))
+		outstream := C.LUCY_Folder_Open_Out(ivars.folder, filenameCF)
+		writer.WriteTo(outstream, int32(maxDoc))
+		C.LUCY_OutStream_Close(outstream)
+		C.cfish_decref(unsafe.Pointer(outstream))
+		C.cfish_decref(unsafe.Pointer(filenameCF))
+		C.free(unsafe.Pointer(cstr))
+	}
+}
+
+// Per-segment VectorReaders, opened lazily the first time a KNNQuery
+// touches a given segment/field and kept around until VectorReader_Close
+// tears the segment's entry down, the same way VectorWriter_Finish tears
+// down the writer side.
+var (
+	vectorReaderMutex sync.Mutex
+	vectorReaders     = make(map[uintptr]map[int]*VectorReader)
+)
+
+func fetchVectorReader(folder *C.lucy_Folder, segment *C.lucy_Segment, fieldNum int) *VectorReader {
+	vectorReaderMutex.Lock()
+	defer vectorReaderMutex.Unlock()
+	key := uintptr(unsafe.Pointer(segment))
+	perField, ok := vectorReaders[key]
+	if !ok {
+		perField = make(map[int]*VectorReader)
+		vectorReaders[key] = perField
+	}
+	reader, ok := perField[fieldNum]
+	if ok {
+		return reader
+	}
+	filename := vectorFilename(fieldNum)
+	cstr := C.CString(filename)
+	filenameCF := C.cfish_Str_new_from_trusted_utf8(cstr, C.size_t(len(filename)))
+	instream := C.LUCY_Folder_Open_In(folder, filenameCF)
+	C.cfish_decref(unsafe.Pointer(filenameCF))
+	C.free(unsafe.Pointer(cstr))
+	reader = NewVectorReader(instream, int32(C.LUCY_Seg_Get_Count(segment)))
+	C.LUCY_InStream_Close(instream)
+	C.cfish_decref(unsafe.Pointer(instream))
+	perField[fieldNum] = reader
+	return reader
+}
+
+func closeVectorReaders(segment *C.lucy_Segment) {
+	vectorReaderMutex.Lock()
+	defer vectorReaderMutex.Unlock()
+	delete(vectorReaders, uintptr(unsafe.Pointer(segment)))
+}
+
+//export GOLUCY_VectorReader_Close
+func GOLUCY_VectorReader_Close(self *C.lucy_VectorReader) {
+	ivars := C.lucy_VectorReader_IVARS(self)
+	closeVectorReaders(ivars.segment)
+}
+
+//export GOLUCY_VectorMatcher_init
+func GOLUCY_VectorMatcher_init(self *C.lucy_VectorMatcher, folder *C.lucy_Folder,
+	segment *C.lucy_Segment, fieldNum C.int32_t, queryVec *C.float, dim C.int32_t,
+	k C.int32_t) *C.lucy_VectorMatcher {
+	cFloats := (*[1 << 28]C.float)(unsafe.Pointer(queryVec))[:dim:dim]
+	vector := make([]float32, int(dim))
+	for i, f := range cFloats {
+		vector[i] = float32(f)
+	}
+	reader := fetchVectorReader(folder, segment, int(fieldNum))
+	query := &search.KNNQuery{Vector: vector, K: int(k)}
+	matcher := search.NewKNNMatcher(query, reader)
+	registryStoreAtPtr(unsafe.Pointer(self), matcher)
+	return self
+}
+
+//export GOLUCY_VectorMatcher_Next
+func GOLUCY_VectorMatcher_Next(self *C.lucy_VectorMatcher) C.int32_t {
+	matcher, _ := registryFetchAtPtr(unsafe.Pointer(self)).(*search.KNNMatcher)
+	if matcher == nil {
+		return 0
+	}
+	return C.int32_t(matcher.Next())
+}
+
+//export GOLUCY_VectorMatcher_Score
+func GOLUCY_VectorMatcher_Score(self *C.lucy_VectorMatcher) C.float {
+	matcher, _ := registryFetchAtPtr(unsafe.Pointer(self)).(*search.KNNMatcher)
+	if matcher == nil {
+		return 0
+	}
+	return C.float(matcher.Score())
+}
+
+//export GOLUCY_VectorMatcher_Destroy
+func GOLUCY_VectorMatcher_Destroy(self *C.lucy_VectorMatcher) {
+	registryDeleteAtPtr(unsafe.Pointer(self))
+	C.cfish_super_destroy(unsafe.Pointer(self), C.LUCY_VECTORMATCHER)
 }
@@ -0,0 +1,34 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package plan describes Schema field types on the Go side of the Lucy
+// bindings.
+package plan
+
+// VectorField declares a fixed-dimensionality dense float32 vector field,
+// to be registered on a Schema alongside the text and numeric field
+// types. Docs stored against a VectorField must supply a []float32 of
+// exactly Dim elements; Invert_Doc rejects anything else.
+type VectorField struct {
+	Name string
+	Dim  int
+}
+
+// NewVectorField declares a VectorField of the given dimensionality under
+// name, ready to pass to Schema.SpecVectorField.
+func NewVectorField(name string, dim int) VectorField {
+	return VectorField{Name: name, Dim: dim}
+}
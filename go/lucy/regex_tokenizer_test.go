@@ -0,0 +1,71 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lucy
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestRegexTokenSpansMultiByte(t *testing.T) {
+	// Go's \w is ASCII-only, so a pattern meant to span multi-byte
+	// letters needs \p{L} -- exactly the case this test exists to cover.
+	pattern := regexp.MustCompile(`\p{L}+`)
+	buf := []byte("héllo wörld")
+	spans := regexTokenSpans(pattern, buf)
+	want := [][2]int{{0, len("héllo")}, {len("héllo "), len(buf)}}
+	if !reflect.DeepEqual(spans, want) {
+		t.Fatalf("regexTokenSpans(%q) = %v, want %v", buf, spans, want)
+	}
+
+	charOffsets := utf8CharOffsets(buf)
+	gotStart, gotEnd := charOffsets[spans[1][0]], charOffsets[spans[1][1]]
+	if wantStart, wantEnd := int32(6), int32(11); gotStart != wantStart || gotEnd != wantEnd {
+		t.Fatalf("char offsets for %q = [%d,%d), want [%d,%d)",
+			buf, gotStart, gotEnd, wantStart, wantEnd)
+	}
+}
+
+func TestRegexTokenSpansEmptyMatchesDropped(t *testing.T) {
+	pattern := regexp.MustCompile(`x*`)
+	buf := []byte("abc")
+	if spans := regexTokenSpans(pattern, buf); len(spans) != 0 {
+		t.Fatalf("expected empty matches against %q to be dropped, got %v", buf, spans)
+	}
+}
+
+func TestRegexTokenSpansUnicodeClass(t *testing.T) {
+	pattern := regexp.MustCompile(`\p{L}+`)
+	buf := []byte("foo123bar")
+	spans := regexTokenSpans(pattern, buf)
+	want := [][2]int{{0, 3}, {6, 9}}
+	if !reflect.DeepEqual(spans, want) {
+		t.Fatalf("regexTokenSpans(%q) = %v, want %v", buf, spans, want)
+	}
+}
+
+func TestUtf8CharOffsetsMultiByte(t *testing.T) {
+	buf := []byte("héllo")
+	offsets := utf8CharOffsets(buf)
+	// h(0) é(1, 2 bytes) l(2) l(3) o(4); offsets[2] (mid-rune) is never a
+	// match boundary so it's left at its zero value.
+	want := []int32{0, 1, 0, 2, 3, 4, 5}
+	if !reflect.DeepEqual(offsets, want) {
+		t.Fatalf("utf8CharOffsets(%q) = %v, want %v", buf, offsets, want)
+	}
+}
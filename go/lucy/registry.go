@@ -0,0 +1,104 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lucy
+
+import (
+	"runtime/cgo"
+	"sync"
+	"unsafe"
+)
+
+// docFields is what a Doc's `fields` void* ivar actually points at once
+// decoded: the Go-side field map, plus a reference count since
+// GOLUCY_Doc_Set_Fields can hand the same map to more than one Doc, the
+// way cfish_inc_refcount/cfish_decref share a cfish_Hash.
+type docFields struct {
+	values map[string]interface{}
+	refs   int32
+}
+
+// fieldsHandle and fieldsPointer round-trip a *docFields through the
+// cgo.Handle stashed in a lucy_Doc's `fields` ivar. cgo.Handle is the
+// standard library's answer to exactly this problem -- carrying a Go
+// value across a C void* boundary without handing the GC a pointer value
+// it didn't allocate -- so Doc no longer needs its own handle table.
+func fieldsHandle(fields unsafe.Pointer) cgo.Handle {
+	return cgo.Handle(uintptr(fields))
+}
+
+func fieldsPointer(h cgo.Handle) unsafe.Pointer {
+	return unsafe.Pointer(uintptr(h))
+}
+
+// newFields allocates a fresh, empty field map under a new handle with a
+// reference count of 1, and returns the ivar value for it.
+func newFields() unsafe.Pointer {
+	return newFieldsFrom(make(map[string]interface{}))
+}
+
+// newFieldsFrom wraps an already-built field map (e.g. one just decoded
+// by Deserialize) in a new handle with a reference count of 1.
+func newFieldsFrom(values map[string]interface{}) unsafe.Pointer {
+	entry := &docFields{values: values, refs: 1}
+	return fieldsPointer(cgo.NewHandle(entry))
+}
+
+func fieldsEntry(fields unsafe.Pointer) *docFields {
+	return fieldsHandle(fields).Value().(*docFields)
+}
+
+// fieldsIncRef bumps the reference count behind fields, mirroring
+// cfish_inc_refcount for a handle shared by more than one Doc.
+func fieldsIncRef(fields unsafe.Pointer) {
+	fieldsEntry(fields).refs++
+}
+
+// fieldsRelease decrements the reference count behind fields and deletes
+// the handle once it drops to zero, mirroring cfish_decref.
+func fieldsRelease(fields unsafe.Pointer) {
+	entry := fieldsEntry(fields)
+	entry.refs--
+	if entry.refs <= 0 {
+		fieldsHandle(fields).Delete()
+	}
+}
+
+// Some C structs, unlike lucy_Doc, have no spare void* ivar to disguise a
+// registry handle in. For those, key the same registry concept directly
+// off the struct's own address instead of minting a cgo.Handle.
+var (
+	ptrRegistryMutex sync.Mutex
+	ptrRegistryTable = make(map[uintptr]interface{})
+)
+
+func registryStoreAtPtr(key unsafe.Pointer, value interface{}) {
+	ptrRegistryMutex.Lock()
+	defer ptrRegistryMutex.Unlock()
+	ptrRegistryTable[uintptr(key)] = value
+}
+
+func registryFetchAtPtr(key unsafe.Pointer) interface{} {
+	ptrRegistryMutex.Lock()
+	defer ptrRegistryMutex.Unlock()
+	return ptrRegistryTable[uintptr(key)]
+}
+
+func registryDeleteAtPtr(key unsafe.Pointer) {
+	ptrRegistryMutex.Lock()
+	defer ptrRegistryMutex.Unlock()
+	delete(ptrRegistryTable, uintptr(key))
+}
@@ -0,0 +1,46 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lucy
+
+/*
+#include "lucy_parcel.h"
+#include "Lucy/Plan/Schema.h"
+#include "Lucy/Plan/VectorType.h"
+*/
+import "C"
+import (
+	"unsafe"
+
+	"git-wip-us.apache.org/repos/asf/lucy.git/go/lucy/plan"
+)
+
+// SpecVectorField registers f on the schema, the VectorField counterpart
+// of the Spec_Field calls the generated FieldType bindings make for text
+// and numeric fields. It must be called before any Doc with that field is
+// inverted, same as specifying any other field type.
+func (s Schema) SpecVectorField(f plan.VectorField) {
+	cname := C.CString(f.Name)
+	defer C.free(unsafe.Pointer(cname))
+	name := C.cfish_Str_new_from_trusted_utf8(cname, C.size_t(len(f.Name)))
+	defer C.cfish_decref(unsafe.Pointer(name))
+
+	vecType := C.lucy_VectorType_new(C.int32_t(f.Dim))
+	defer C.cfish_decref(unsafe.Pointer(vecType))
+
+	schemaCF := (*C.lucy_Schema)(s.TOPTR())
+	C.LUCY_Schema_Spec_Field(schemaCF, name, (*C.lucy_FieldType)(unsafe.Pointer(vecType)))
+}
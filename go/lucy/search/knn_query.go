@@ -0,0 +1,105 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package search implements Lucy's query and matcher types on the Go
+// side of the bindings.
+package search
+
+import (
+	"math"
+	"sort"
+)
+
+// KNNQuery finds the K docs whose VectorField value is most similar, by
+// cosine similarity, to Vector. It can be combined with a lexical BM25
+// query against the same Collector to blend semantic and keyword recall.
+type KNNQuery struct {
+	Field  string
+	Vector []float32
+	K      int
+}
+
+// VectorReader is the per-segment column a KNNMatcher scans. It's
+// satisfied by lucy.VectorReader; kept as an interface here so the scan
+// strategy (flat today, perhaps HNSW-backed later) can change without
+// touching KNNMatcher.
+type VectorReader interface {
+	Vector(docID int32) []float32
+	MaxDoc() int32
+}
+
+type scoredDoc struct {
+	docID int32
+	score float32
+}
+
+// KNNMatcher scans one segment's vector column and returns its K nearest
+// neighbors to the query vector by cosine similarity. The Collector
+// merges per-segment KNNMatchers the same way it merges BM25 Matchers.
+type KNNMatcher struct {
+	results []scoredDoc
+	pos     int
+}
+
+// NewKNNMatcher scans reader for query's K nearest neighbors. Docs with
+// no value for the field are skipped rather than scored zero.
+func NewKNNMatcher(query *KNNQuery, reader VectorReader) *KNNMatcher {
+	results := make([]scoredDoc, 0, query.K)
+	for docID := int32(1); docID <= reader.MaxDoc(); docID++ {
+		vector := reader.Vector(docID)
+		if vector == nil {
+			continue
+		}
+		results = append(results, scoredDoc{docID, cosineSimilarity(query.Vector, vector)})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].score > results[j].score })
+	if len(results) > query.K {
+		results = results[:query.K]
+	}
+	return &KNNMatcher{results: results, pos: -1}
+}
+
+// Next advances to the next-best-scoring doc and returns its doc ID, or 0
+// once the K nearest neighbors have been exhausted.
+func (m *KNNMatcher) Next() int32 {
+	m.pos++
+	if m.pos >= len(m.results) {
+		return 0
+	}
+	return m.results[m.pos].docID
+}
+
+// Score returns the cosine similarity of the current doc to the query
+// vector.
+func (m *KNNMatcher) Score() float32 {
+	if m.pos < 0 || m.pos >= len(m.results) {
+		return 0
+	}
+	return m.results[m.pos].score
+}
+
+func cosineSimilarity(a, b []float32) float32 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return float32(dot / (math.Sqrt(normA) * math.Sqrt(normB)))
+}